@@ -0,0 +1,125 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// ErrBackupAborted is returned by BackupTo/SnapshotToFile when the progress
+// callback installed with SetBackupProgress returns false.
+var ErrBackupAborted = errors.New("unqlite: backup aborted by progress callback")
+
+// SetBackupProgress installs fn to be called after every record copied by
+// BackupTo/SnapshotToFile, reporting how many records have been copied so
+// far and the total known up front. Returning false aborts the backup.
+func (db *Database) SetBackupProgress(fn func(copied, total int64) bool) {
+	db.backupProgress = fn
+}
+
+// ImportMmap memory-maps path via unqlite_util_load_mmaped_file and returns
+// a zero-copy []byte view over it, along with a release func that must be
+// called (exactly once) when the caller is done with the slice. This lets
+// large blobs be handed to Store without a Go-side copy.
+func (db *Database) ImportMmap(path string) ([]byte, func() error, error) {
+	cz := C.CString(path)
+	defer C.free(unsafe.Pointer(cz))
+
+	var pMap unsafe.Pointer
+	var size C.unqlite_int64
+
+	if res := C.unqlite_util_load_mmaped_file(cz, &pMap, &size); res != C.UNQLITE_OK {
+		return nil, nil, UnQLiteError(res)
+	}
+
+	data := unsafe.Slice((*byte)(pMap), int(size))
+	released := false
+	release := func() error {
+		if released {
+			return nil
+		}
+		released = true
+		if res := C.unqlite_util_release_mmaped_file(pMap, size); res != C.UNQLITE_OK {
+			return UnQLiteError(res)
+		}
+		return nil
+	}
+	return data, release, nil
+}
+
+// BackupTo copies every key/value pair from db into dst inside a single
+// Begin/Commit on dst. It is safe to run concurrently with writers on db:
+// reads ride the busy handler installed with
+// SetBusyTimeout/SetBusyHandler like any other cursor iteration.
+func (db *Database) BackupTo(dst *Database) error {
+	total, err := db.countEntries()
+	if err != nil {
+		return err
+	}
+
+	if err := dst.Begin(); err != nil {
+		return err
+	}
+
+	var copied int64
+	curs, err := db.NewCursor()
+	if err != nil {
+		dst.Rollback()
+		return err
+	}
+	defer curs.Close()
+
+	for key, value := range curs.All() {
+		if err := dst.Store(key, value); err != nil {
+			dst.Rollback()
+			return err
+		}
+		copied++
+		if db.backupProgress != nil && !db.backupProgress(copied, total) {
+			dst.Rollback()
+			return ErrBackupAborted
+		}
+	}
+	if err := curs.Err(); err != nil {
+		dst.Rollback()
+		return err
+	}
+
+	return dst.Commit()
+}
+
+// SnapshotToFile backs up db into the database file at path, creating it if
+// it does not exist. Records already in an existing file are kept; those
+// under a key that db also holds are overwritten.
+func (db *Database) SnapshotToFile(path string) error {
+	dst, err := NewDatabaseWithOptions(path, nil)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	return db.BackupTo(dst)
+}
+
+// countEntries does a cheap read-only pass over db to report the total
+// record count up front, for the progress callback's benefit.
+func (db *Database) countEntries() (int64, error) {
+	curs, err := db.NewCursor()
+	if err != nil {
+		return 0, err
+	}
+	defer curs.Close()
+
+	var n int64
+	for range curs.All() {
+		n++
+	}
+	if err := curs.Err(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
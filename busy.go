@@ -0,0 +1,132 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+*/
+import "C"
+
+import (
+	"sync"
+	"time"
+)
+
+// SetBusyTimeout retries any call that would otherwise return UNQLITE_BUSY
+// for up to d, with exponential backoff shortened by an unlock-notify
+// wakeup (see retry). It is the equivalent of sqlite3_busy_timeout. Passing
+// d <= 0 disables retrying. It clears any handler installed with
+// SetBusyHandler.
+func (db *Database) SetBusyTimeout(d time.Duration) {
+	db.busyMutex.Lock()
+	defer db.busyMutex.Unlock()
+	db.busyHandler = nil
+	db.busyTimeout = d
+}
+
+// SetBusyHandler installs a custom busy handler, overriding any timeout set
+// with SetBusyTimeout. fn is called with the zero-based retry attempt
+// number each time an operation returns UNQLITE_BUSY; returning true
+// retries the operation, false surfaces the UnQLiteError(UNQLITE_BUSY) to
+// the caller. Between attempts, retry parks in waitForUnlock, which any
+// Database's Commit/Rollback wakes through notifyUnlock, the process-local
+// equivalent of sqlite3_unlock_notify, so a retry can wake up as soon as
+// the lock is released instead of only on its next backoff tick.
+func (db *Database) SetBusyHandler(fn func(attempt int) bool) {
+	db.busyMutex.Lock()
+	defer db.busyMutex.Unlock()
+	db.busyHandler = fn
+}
+
+// busyWaiters holds the channels of goroutines currently parked in
+// waitForUnlock. It replaces a sync.Cond broadcast: a Cond requires the
+// waiting goroutine to already be inside Wait() when Broadcast runs, and
+// waitForUnlock used to spawn that goroutine and race it against its own
+// backoff timer, so a broadcast landing before the goroutine reached Wait()
+// woke no one and the wait leaked until some unrelated Commit/Rollback
+// happened to broadcast again. Registering a channel here under busyMu
+// before ever blocking removes that race entirely: notifyUnlock can only
+// run after the channel is in the map, or after waitForUnlock has already
+// removed it and moved on.
+var (
+	busyMu      sync.Mutex
+	busyWaiters = make(map[chan struct{}]struct{})
+)
+
+func notifyUnlock() {
+	busyMu.Lock()
+	for ch := range busyWaiters {
+		close(ch)
+	}
+	busyWaiters = make(map[chan struct{}]struct{})
+	busyMu.Unlock()
+}
+
+// waitForUnlock blocks until either d elapses or some Database's
+// Commit/Rollback calls notifyUnlock, whichever comes first.
+func waitForUnlock(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	ch := make(chan struct{})
+	busyMu.Lock()
+	busyWaiters[ch] = struct{}{}
+	busyMu.Unlock()
+
+	select {
+	case <-ch:
+	case <-time.After(d):
+		busyMu.Lock()
+		delete(busyWaiters, ch)
+		busyMu.Unlock()
+	}
+}
+
+// retry runs op, which should make exactly one call into unqlite and return
+// its raw result code, retrying while it returns UNQLITE_BUSY and the
+// configured busy handler (or busyTimeout deadline) allows it. Between
+// attempts it waits in waitForUnlock, capped by an exponential backoff starting
+// at 1ms and capping at 100ms. UNQLITE_LOCKED is not retried: it means this
+// same connection already holds a transaction that conflicts with itself,
+// and retrying would deadlock forever, the same nested-lock case the
+// sqlite3_unlock_notify patch guards against.
+//
+// The busyTimeout deadline is tracked in a local variable here rather than
+// inside a closure returned from SetBusyTimeout: db.busyHandler/busyTimeout
+// are shared by every retry call on the Database, so a deadline stored in
+// shared state would race across concurrent retries. Each call to retry
+// gets its own deadline instead.
+func (db *Database) retry(op func() C.int) C.int {
+	db.busyMutex.Lock()
+	handler := db.busyHandler
+	timeout := db.busyTimeout
+	db.busyMutex.Unlock()
+
+	var deadline time.Time
+	for attempt := 0; ; attempt++ {
+		rc := op()
+		if rc != C.UNQLITE_BUSY {
+			return rc
+		}
+
+		switch {
+		case handler != nil:
+			if !handler(attempt) {
+				return rc
+			}
+		case timeout > 0:
+			if attempt == 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			if !time.Now().Before(deadline) {
+				return rc
+			}
+		default:
+			return rc
+		}
+
+		wait := 100 * time.Millisecond
+		if attempt < 7 {
+			wait = time.Millisecond << uint(attempt)
+		}
+		waitForUnlock(wait)
+	}
+}
@@ -0,0 +1,105 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+*/
+import "C"
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRetryConcurrentDeadlines exercises retry's busyTimeout path from
+// several goroutines at once on the same Database, the scenario that used
+// to race on a deadline shared across calls in a closure returned from
+// SetBusyTimeout. Run with -race.
+func TestRetryConcurrentDeadlines(t *testing.T) {
+	db := &Database{}
+	db.SetBusyTimeout(20 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts := 0
+			rc := db.retry(func() C.int {
+				attempts++
+				return C.UNQLITE_BUSY
+			})
+			if rc != C.UNQLITE_BUSY {
+				t.Errorf("retry returned %v, want UNQLITE_BUSY", rc)
+			}
+			if attempts < 2 {
+				t.Errorf("op ran only %d time(s), want at least 2 retries before the deadline", attempts)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestRetrySucceedsWithoutRetrying confirms retry runs op only once when it
+// succeeds on the first attempt.
+func TestRetrySucceedsWithoutRetrying(t *testing.T) {
+	db := &Database{}
+	db.SetBusyTimeout(time.Second)
+
+	calls := 0
+	rc := db.retry(func() C.int {
+		calls++
+		return C.UNQLITE_OK
+	})
+	if rc != C.UNQLITE_OK {
+		t.Fatalf("retry returned %v, want UNQLITE_OK", rc)
+	}
+	if calls != 1 {
+		t.Fatalf("op called %d times, want 1", calls)
+	}
+}
+
+// TestWaitForUnlockReturnsAfterTimeout guards against the goroutine leak in
+// waitForUnlock: if nothing ever calls notifyUnlock, the call must still
+// return once d elapses instead of blocking forever.
+func TestWaitForUnlockReturnsAfterTimeout(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		waitForUnlock(10 * time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waitForUnlock did not return after its timeout elapsed")
+	}
+}
+
+// TestNotifyUnlockBeforeWaitDoesNotLeak guards against the lost-wakeup race
+// a Cond-based waitForUnlock used to have: notifyUnlock firing before the
+// waiter actually starts blocking must not leave it parked until its
+// timeout. The first notifyUnlock runs with no waiter registered and must
+// have no effect on the later wait; the second runs once the waiter has
+// had time to register and must wake it.
+func TestNotifyUnlockBeforeWaitDoesNotLeak(t *testing.T) {
+	notifyUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		waitForUnlock(time.Second)
+		close(done)
+	}()
+
+	// waitForUnlock registers itself before blocking, so a notifyUnlock
+	// that happens to run concurrently with, or shortly after, that
+	// registration must still wake it well before the 1s timeout.
+	time.Sleep(10 * time.Millisecond)
+	notifyUnlock()
+
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("waitForUnlock did not return after notifyUnlock, it waited out its full timeout")
+	}
+}
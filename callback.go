@@ -0,0 +1,222 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+#include <stdlib.h>
+
+extern int goChunkConsumer(void *pData, unsigned int nDatalen, void *pUserData);
+*/
+import "C"
+
+import (
+	"bytes"
+	"io"
+	"iter"
+	"unsafe"
+)
+
+// chunkSink receives zero-copy slices aliasing the C buffer handed to a
+// *_callback entry point. The slice is only valid for the duration of the
+// call and must not escape.
+type chunkSink func(chunk []byte) error
+
+// sinks is a process-wide registry of in-flight chunkSinks, keyed by a
+// handle passed through pUserData. unqlite invokes goChunkConsumer
+// re-entrantly on the same goroutine that registered the handle, so it only
+// ever needs to hold one entry per concurrent call.
+var sinks = newHandleRegistry[chunkSink]()
+
+func registerSink(fn chunkSink) uintptr {
+	return sinks.register(fn)
+}
+
+func releaseSink(h uintptr) {
+	sinks.release(h)
+}
+
+//export goChunkConsumer
+func goChunkConsumer(pData unsafe.Pointer, nDatalen C.uint, pUserData unsafe.Pointer) C.int {
+	fn := sinks.lookup(uintptr(pUserData))
+	if fn == nil {
+		return C.UNQLITE_ABORT
+	}
+
+	chunk := unsafe.Slice((*byte)(pData), int(nDatalen))
+	if err := fn(chunk); err != nil {
+		return C.UNQLITE_ABORT
+	}
+	return C.UNQLITE_OK
+}
+
+// FetchTo streams the value stored under key directly into w, never
+// allocating an intermediate []byte for the full value. n reports the
+// number of bytes written.
+func (db *Database) FetchTo(key []byte, w io.Writer) (n int64, err error) {
+	var writeErr error
+	err = db.FetchFunc(key, func(chunk []byte) error {
+		wn, werr := w.Write(chunk)
+		n += int64(wn)
+		writeErr = werr
+		return werr
+	})
+	// writeErr, when set, is the reason fn returned an error in the first
+	// place; err at this point is just UnQLiteError(UNQLITE_ABORT), the
+	// generic code unqlite_kv_fetch_callback reports for any non-nil
+	// return from the C callback, so it carries none of that detail.
+	if writeErr != nil {
+		err = writeErr
+	}
+	return
+}
+
+// FetchFunc fetches the value stored under key, invoking fn with one or
+// more zero-copy slices aliasing the C buffer. fn's chunk argument must not
+// escape the call: copy it if you need to retain the data.
+func (db *Database) FetchFunc(key []byte, fn func(chunk []byte) error) error {
+	var k unsafe.Pointer
+	if len(key) > 0 {
+		k = unsafe.Pointer(&key[0])
+	}
+
+	h := registerSink(fn)
+	defer releaseSink(h)
+
+	res := db.retry(func() C.int {
+		return C.unqlite_kv_fetch_callback(db.handle, k, C.int(len(key)),
+			(*[0]byte)(C.goChunkConsumer), unsafe.Pointer(h))
+	})
+	if res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+	return nil
+}
+
+// KeyTo streams the cursor's current key directly into w without an
+// intermediate allocation.
+func (curs *Cursor) KeyTo(w io.Writer) (n int64, err error) {
+	var writeErr error
+	h := registerSink(func(chunk []byte) error {
+		wn, werr := w.Write(chunk)
+		n += int64(wn)
+		writeErr = werr
+		return werr
+	})
+	defer releaseSink(h)
+
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_key_callback(curs.handle,
+			(*[0]byte)(C.goChunkConsumer), unsafe.Pointer(h))
+	})
+	if res != C.UNQLITE_OK {
+		return n, UnQLiteError(res)
+	}
+	return n, writeErr
+}
+
+// ValueTo streams the cursor's current value directly into w without an
+// intermediate allocation.
+func (curs *Cursor) ValueTo(w io.Writer) (n int64, err error) {
+	var writeErr error
+	h := registerSink(func(chunk []byte) error {
+		wn, werr := w.Write(chunk)
+		n += int64(wn)
+		writeErr = werr
+		return werr
+	})
+	defer releaseSink(h)
+
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_data_callback(curs.handle,
+			(*[0]byte)(C.goChunkConsumer), unsafe.Pointer(h))
+	})
+	if res != C.UNQLITE_OK {
+		return n, UnQLiteError(res)
+	}
+	return n, writeErr
+}
+
+// Err reports the first error rangeFrom hit while advancing the cursor or
+// reading a key/value mid-scan during the most recent All/Range iteration.
+// Running off the end of the keyspace (or past a Range's hi bound) is not
+// an error and leaves Err nil; check Err after ranging to tell that normal
+// case apart from a scan that stopped early because a read failed.
+func (curs *Cursor) Err() error {
+	return curs.lastErr
+}
+
+// All returns an iterator over every key/value pair in the store, starting
+// from the cursor's first entry. Each yielded []byte is reused on the next
+// iteration step and must not escape the loop body; copy it if you need to
+// keep it. Check Err after ranging to distinguish a scan that reached the
+// end of the keyspace from one that stopped early on an error.
+func (curs *Cursor) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func(k, v []byte) bool) {
+		curs.lastErr = nil
+		if err := curs.First(); err != nil {
+			return
+		}
+		curs.rangeFrom(nil, yield)
+	}
+}
+
+// Range returns an iterator over every key/value pair with key in [lo, hi),
+// built on the callback-based key/value APIs so large scans do not
+// allocate per record. Check Err after ranging to distinguish a scan that
+// reached hi from one that stopped early on an error.
+func (curs *Cursor) Range(lo, hi []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func(k, v []byte) bool) {
+		curs.lastErr = nil
+		if err := curs.SeekGE(lo); err != nil {
+			return
+		}
+		curs.rangeFrom(hi, yield)
+	}
+}
+
+func (curs *Cursor) rangeFrom(hi []byte, yield func(k, v []byte) bool) {
+	var keyBuf, valBuf bytes.Buffer
+	for curs.IsValid() {
+		keyBuf.Reset()
+		if _, err := curs.KeyTo(&keyBuf); err != nil {
+			curs.lastErr = err
+			return
+		}
+		key := keyBuf.Bytes()
+		if hi != nil && bytesCompare(key, hi) >= 0 {
+			return
+		}
+
+		valBuf.Reset()
+		if _, err := curs.ValueTo(&valBuf); err != nil {
+			curs.lastErr = err
+			return
+		}
+
+		if !yield(key, valBuf.Bytes()) {
+			return
+		}
+		if err := curs.Next(); err != nil {
+			curs.lastErr = err
+			return
+		}
+	}
+}
+
+func bytesCompare(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	default:
+		return 0
+	}
+}
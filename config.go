@@ -0,0 +1,214 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+#include <stdlib.h>
+
+extern unsigned int goKVHashFunc(const void *pKey, unsigned int nKeyLen);
+extern int goKVCmpFunc(const void *pKey1, unsigned int nKeyLen1, const void *pKey2, unsigned int nKeyLen2);
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// Options configures a Database at open time, the storage-engine and
+// tuning knobs documented for unqlite_config/unqlite_kv_config.
+type Options struct {
+	// KVEngine selects the storage engine: "hash" (default), "mem", or
+	// "r+tree", passed to UNQLITE_CONFIG_KV_ENGINE.
+	KVEngine string
+
+	// MaxPageCache caps the page cache size in pages
+	// (UNQLITE_CONFIG_MAX_PAGE_CACHE). Zero leaves the engine default.
+	MaxPageCache int
+
+	// DisableAutoCommit turns off UnQLite's implicit auto-commit
+	// (UNQLITE_CONFIG_DISABLE_AUTO_COMMIT), requiring explicit
+	// Begin/Commit around every write.
+	DisableAutoCommit bool
+
+	// HashFunc, if set, replaces the KV store's default hash function
+	// (UNQLITE_KV_CONFIG_HASH_FUNC).
+	HashFunc func(key []byte) uint32
+
+	// CmpFunc, if set, replaces the KV store's default key comparator
+	// (UNQLITE_KV_CONFIG_CMP_FUNC), ordering records for range scans and
+	// R+Tree lookups.
+	CmpFunc func(a, b []byte) int
+}
+
+// NewDatabaseWithOptions opens filename with opts applied before the
+// database file is created/loaded, bypassing the process-wide cache used
+// by NewDatabase since configuration is per-handle.
+func NewDatabaseWithOptions(filename string, opts *Options) (db *Database, err error) {
+	db = &Database{name: filename}
+	name := C.CString(filename)
+	defer C.free(unsafe.Pointer(name))
+
+	if res := C.unqlite_open(&db.handle, name, C.UNQLITE_OPEN_CREATE); res != C.UNQLITE_OK {
+		return nil, UnQLiteError(res)
+	}
+
+	if opts != nil {
+		if err = db.applyOptions(opts); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	runtime.SetFinalizer(db, (*Database).Close)
+	return db, nil
+}
+
+func (db *Database) applyOptions(opts *Options) error {
+	if opts.KVEngine != "" {
+		if err := db.Config(C.UNQLITE_CONFIG_KV_ENGINE, opts.KVEngine); err != nil {
+			return err
+		}
+	}
+	if opts.MaxPageCache > 0 {
+		if err := db.Config(C.UNQLITE_CONFIG_MAX_PAGE_CACHE, opts.MaxPageCache); err != nil {
+			return err
+		}
+	}
+	if opts.DisableAutoCommit {
+		if err := db.Config(C.UNQLITE_CONFIG_DISABLE_AUTO_COMMIT); err != nil {
+			return err
+		}
+	}
+	if opts.HashFunc != nil {
+		if err := db.KVConfig(C.UNQLITE_KV_CONFIG_HASH_FUNC, opts.HashFunc); err != nil {
+			return err
+		}
+	}
+	if opts.CmpFunc != nil {
+		if err := db.KVConfig(C.UNQLITE_KV_CONFIG_CMP_FUNC, opts.CmpFunc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config is the low-level escape hatch for unqlite_config, for options not
+// covered by Options. args are marshalled into the C types the given op
+// expects; see the UNQLITE_CONFIG_* constants in unqlite.h for each op's
+// argument list.
+func (db *Database) Config(op int, args ...interface{}) error {
+	switch op {
+	case C.UNQLITE_CONFIG_KV_ENGINE:
+		name, ok := args[0].(string)
+		if !ok {
+			return fmt.Errorf("unqlite: UNQLITE_CONFIG_KV_ENGINE wants a string, got %T", args[0])
+		}
+		cz := C.CString(name)
+		defer C.free(unsafe.Pointer(cz))
+		return checkRes(C.unqlite_config(db.handle, C.int(op), cz))
+
+	case C.UNQLITE_CONFIG_MAX_PAGE_CACHE:
+		n, ok := args[0].(int)
+		if !ok {
+			return fmt.Errorf("unqlite: UNQLITE_CONFIG_MAX_PAGE_CACHE wants an int, got %T", args[0])
+		}
+		return checkRes(C.unqlite_config(db.handle, C.int(op), C.int(n)))
+
+	case C.UNQLITE_CONFIG_DISABLE_AUTO_COMMIT:
+		return checkRes(C.unqlite_config(db.handle, C.int(op)))
+
+	case C.UNQLITE_CONFIG_JX9_ERR_LOG, C.UNQLITE_CONFIG_ERR_LOG:
+		var buf *C.char
+		var n C.int
+		res := C.unqlite_config(db.handle, C.int(op), &buf, &n)
+		if res == C.UNQLITE_OK && len(args) > 0 {
+			if out, ok := args[0].(*string); ok {
+				*out = C.GoStringN(buf, n)
+			}
+		}
+		return checkRes(res)
+
+	default:
+		return fmt.Errorf("unqlite: unsupported Config op %d", op)
+	}
+}
+
+// KVConfig is the low-level escape hatch for unqlite_kv_config.
+func (db *Database) KVConfig(op int, args ...interface{}) error {
+	switch op {
+	case C.UNQLITE_KV_CONFIG_HASH_FUNC:
+		fn, ok := args[0].(func(key []byte) uint32)
+		if !ok {
+			return fmt.Errorf("unqlite: UNQLITE_KV_CONFIG_HASH_FUNC wants a func([]byte) uint32, got %T", args[0])
+		}
+		setHashFunc(fn)
+		return checkRes(C.unqlite_kv_config(db.handle, C.int(op), (*[0]byte)(C.goKVHashFunc)))
+
+	case C.UNQLITE_KV_CONFIG_CMP_FUNC:
+		fn, ok := args[0].(func(a, b []byte) int)
+		if !ok {
+			return fmt.Errorf("unqlite: UNQLITE_KV_CONFIG_CMP_FUNC wants a func([]byte, []byte) int, got %T", args[0])
+		}
+		setCmpFunc(fn)
+		return checkRes(C.unqlite_kv_config(db.handle, C.int(op), (*[0]byte)(C.goKVCmpFunc)))
+
+	default:
+		return fmt.Errorf("unqlite: unsupported KVConfig op %d", op)
+	}
+}
+
+func checkRes(res C.int) error {
+	if res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+	return nil
+}
+
+// unqlite_kv_config's HASH_FUNC/CMP_FUNC callbacks are not passed any
+// per-database user data, so only one Go hash/comparator function can be
+// active process-wide at a time; setting a new one on another Database
+// replaces it for all of them.
+var (
+	kvFuncMutex sync.Mutex
+	kvHashFunc  func(key []byte) uint32
+	kvCmpFunc   func(a, b []byte) int
+)
+
+func setHashFunc(fn func(key []byte) uint32) {
+	kvFuncMutex.Lock()
+	defer kvFuncMutex.Unlock()
+	kvHashFunc = fn
+}
+
+func setCmpFunc(fn func(a, b []byte) int) {
+	kvFuncMutex.Lock()
+	defer kvFuncMutex.Unlock()
+	kvCmpFunc = fn
+}
+
+//export goKVHashFunc
+func goKVHashFunc(pKey unsafe.Pointer, nKeyLen C.uint) C.uint {
+	kvFuncMutex.Lock()
+	fn := kvHashFunc
+	kvFuncMutex.Unlock()
+	if fn == nil {
+		return 0
+	}
+	key := unsafe.Slice((*byte)(pKey), int(nKeyLen))
+	return C.uint(fn(key))
+}
+
+//export goKVCmpFunc
+func goKVCmpFunc(pKey1 unsafe.Pointer, nKeyLen1 C.uint, pKey2 unsafe.Pointer, nKeyLen2 C.uint) C.int {
+	kvFuncMutex.Lock()
+	fn := kvCmpFunc
+	kvFuncMutex.Unlock()
+	if fn == nil {
+		return 0
+	}
+	key1 := unsafe.Slice((*byte)(pKey1), int(nKeyLen1))
+	key2 := unsafe.Slice((*byte)(pKey2), int(nKeyLen2))
+	return C.int(fn(key1, key2))
+}
@@ -0,0 +1,419 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+#include <stdlib.h>
+
+extern int goRowWalker(unqlite_value *pKey, unqlite_value *pValue, void *pUserData);
+extern int goFieldWalker(unqlite_value *pKey, unqlite_value *pValue, void *pUserData);
+*/
+import "C"
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// outputVar is the name of the Jx9 variable a query must populate with its
+// result set. It must be bound to an array of objects, e.g.:
+//
+//	$out = db_fetch_all('users');
+const outputVar = "out"
+
+func init() {
+	sql.Register("unqlite", &sqlDriver{})
+}
+
+// sqlDriver implements database/sql/driver.Driver on top of the Jx9
+// virtual machine.
+type sqlDriver struct{}
+
+// Open ...
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	db, err := NewDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{db: db}, nil
+}
+
+// sqlConn is a database/sql/driver.Conn backed by a *Database.
+type sqlConn struct {
+	db *Database
+}
+
+// Prepare ...
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return &sqlStmt{conn: c, query: query}, nil
+}
+
+// Close ...
+func (c *sqlConn) Close() error {
+	return c.db.Close()
+}
+
+// Begin ...
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	if err := c.db.Begin(); err != nil {
+		return nil, err
+	}
+	return &sqlTx{db: c.db}, nil
+}
+
+// BeginTx ...
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+
+// QueryContext runs query as a Jx9 program and extracts $out as the result
+// set.
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.execJx9(ctx, query, args)
+}
+
+// ExecContext runs query as a Jx9 program and reports the number of rows
+// populated into $out, if any, as RowsAffected.
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.execJx9(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(int64(len(rows.rows))), nil
+}
+
+// execJx9 compiles query, binds args as Jx9 variables via
+// unqlite_vm_config(UNQLITE_VM_CONFIG_CREATE_VAR, ...), runs it, and
+// extracts $out into a *sqlRows.
+func (c *sqlConn) execJx9(ctx context.Context, query string, args []driver.NamedValue) (*sqlRows, error) {
+	qz := C.CString(query)
+	defer C.free(unsafe.Pointer(qz))
+
+	var vm *C.unqlite_vm
+	if rc := C.unqlite_compile(c.db.handle, qz, C.int(len(query)), &vm); rc != C.UNQLITE_OK {
+		return nil, fmt.Errorf("unqlite: jx9 compile error: %w", UnQLiteError(rc))
+	}
+
+	for _, arg := range args {
+		name := arg.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", arg.Ordinal)
+		}
+		if err := bindVMVar(vm, name, arg.Value); err != nil {
+			C.unqlite_vm_release(vm)
+			return nil, err
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if rc := C.unqlite_vm_exec(vm); rc != C.UNQLITE_OK {
+			done <- UnQLiteError(rc)
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case <-ctx.Done():
+		// unqlite_vm_exec may still be running against vm on another
+		// goroutine; only release it once that goroutine actually
+		// finishes, so we never call unqlite_vm_release concurrently
+		// with unqlite_vm_exec.
+		go func() {
+			<-done
+			C.unqlite_vm_release(vm)
+		}()
+		return nil, ctx.Err()
+	case err := <-done:
+		if err != nil {
+			C.unqlite_vm_release(vm)
+			return nil, fmt.Errorf("unqlite: jx9 exec error: %w", err)
+		}
+	}
+	defer C.unqlite_vm_release(vm)
+
+	rows := &sqlRows{}
+
+	nz := C.CString(outputVar)
+	defer C.free(unsafe.Pointer(nz))
+
+	var out *C.unqlite_value
+	if rc := C.unqlite_vm_config(vm, C.UNQLITE_VM_CONFIG_EXTRACT_OUTPUT, nz, &out); rc != C.UNQLITE_OK {
+		return nil, fmt.Errorf("unqlite: could not extract $%s: %w", outputVar, UnQLiteError(rc))
+	}
+	if out == nil {
+		return rows, nil
+	}
+	if C.unqlite_value_is_json_array(out) != 1 {
+		return nil, fmt.Errorf("unqlite: $%s must be a JSON array of objects", outputVar)
+	}
+	if err := walkRows(out, rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// bindVMVar binds v to the Jx9 variable name, creating it with
+// unqlite_vm_config(UNQLITE_VM_CONFIG_CREATE_VAR, ...).
+func bindVMVar(vm *C.unqlite_vm, name string, v driver.Value) error {
+	val, err := newUnqliteValue(vm, v)
+	if err != nil {
+		return err
+	}
+	defer C.unqlite_vm_release_value(vm, val)
+
+	nz := C.CString(name)
+	defer C.free(unsafe.Pointer(nz))
+
+	if rc := C.unqlite_vm_config(vm, C.UNQLITE_VM_CONFIG_CREATE_VAR, nz, val); rc != C.UNQLITE_OK {
+		return fmt.Errorf("unqlite: could not bind $%s: %w", name, UnQLiteError(rc))
+	}
+	return nil
+}
+
+var errUnsupportedValue = errors.New("unqlite: unsupported jx9 value type")
+
+// newUnqliteValue converts a database/sql/driver.Value into a freshly
+// allocated unqlite_value bound to vm's memory subsystem.
+func newUnqliteValue(vm *C.unqlite_vm, v driver.Value) (*C.unqlite_value, error) {
+	val := C.unqlite_vm_new_scalar(vm)
+	if val == nil {
+		return nil, errors.New("unqlite: unqlite_vm_new_scalar failed")
+	}
+
+	switch t := v.(type) {
+	case nil:
+		C.unqlite_value_null(val)
+	case int64:
+		C.unqlite_value_int64(val, C.unqlite_int64(t))
+	case float64:
+		C.unqlite_value_double(val, C.double(t))
+	case bool:
+		b := C.int(0)
+		if t {
+			b = 1
+		}
+		C.unqlite_value_bool(val, b)
+	case []byte:
+		cz := C.CString(string(t))
+		defer C.free(unsafe.Pointer(cz))
+		C.unqlite_value_string(val, cz, C.int(len(t)))
+	case string:
+		cz := C.CString(t)
+		defer C.free(unsafe.Pointer(cz))
+		C.unqlite_value_string(val, cz, C.int(len(t)))
+	default:
+		C.unqlite_vm_release_value(vm, val)
+		return nil, fmt.Errorf("%w: %T", errUnsupportedValue, v)
+	}
+	return val, nil
+}
+
+// sqlTx maps database/sql's Tx onto unqlite_begin/_commit/_rollback.
+type sqlTx struct {
+	db *Database
+}
+
+// Commit ...
+func (tx *sqlTx) Commit() error {
+	return tx.db.Commit()
+}
+
+// Rollback ...
+func (tx *sqlTx) Rollback() error {
+	return tx.db.Rollback()
+}
+
+// sqlStmt is a prepared Jx9 program, re-compiled and re-run on each
+// invocation; no compiled unqlite_vm is kept between calls.
+type sqlStmt struct {
+	conn  *sqlConn
+	query string
+}
+
+// Close ...
+func (s *sqlStmt) Close() error { return nil }
+
+// NumInput reports that parameter count checking is left to the Jx9 VM,
+// since a program may reference any number of bound variables.
+func (s *sqlStmt) NumInput() int { return -1 }
+
+// Exec ...
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamed(args))
+}
+
+// Query ...
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamed(args))
+}
+
+// ExecContext ...
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+// QueryContext ...
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func valuesToNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// sqlRows holds the decoded $out array of row objects.
+type sqlRows struct {
+	cols []string
+	rows []map[string]driver.Value
+	pos  int
+}
+
+// Columns ...
+func (r *sqlRows) Columns() []string { return r.cols }
+
+// Close ...
+func (r *sqlRows) Close() error { return nil }
+
+// Next ...
+func (r *sqlRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	row := r.rows[r.pos]
+	r.pos++
+	for i, col := range r.cols {
+		dest[i] = row[col]
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName reports a coarse SQL type name for column i,
+// based on the first row, mirroring JSON/TEXT/INTEGER/REAL/NULL the way the
+// SQLite Go drivers report column affinities. database/sql calls this via
+// Rows.ColumnTypes() once right after the query runs, before any Next(), so
+// it cannot key off "the most recently read row" the way a streaming
+// cursor would; every row is already decoded up front in r.rows, so the
+// first one stands in for the rest.
+func (r *sqlRows) ColumnTypeDatabaseTypeName(i int) string {
+	if len(r.rows) == 0 {
+		return "JSON"
+	}
+	switch r.rows[0][r.cols[i]].(type) {
+	case int64:
+		return "INTEGER"
+	case float64:
+		return "REAL"
+	case string, []byte:
+		return "TEXT"
+	case nil:
+		return "NULL"
+	default:
+		return "JSON"
+	}
+}
+
+// rowWalkState is threaded through the C array-walk callbacks. It is never
+// passed to cgo directly: pUserData carries an opaque uintptr handle into
+// walkStates instead, since a struct holding Go pointers (rows, cols) would
+// itself be a Go pointer to Go pointers, which cgo's pointer checker
+// rejects.
+//
+// order records a single row's field names in the order goFieldWalker
+// visits them; a map range over the decoded row would not do, since Go's
+// map iteration order is randomized and would make the reported column
+// order nondeterministic across calls.
+type rowWalkState struct {
+	rows  *sqlRows
+	cols  map[string]bool
+	order []string
+}
+
+var walkStates = newHandleRegistry[*rowWalkState]()
+
+func registerWalkState(s *rowWalkState) uintptr {
+	return walkStates.register(s)
+}
+
+func walkStateFor(h unsafe.Pointer) *rowWalkState {
+	return walkStates.lookup(uintptr(h))
+}
+
+func releaseWalkState(h uintptr) {
+	walkStates.release(h)
+}
+
+// walkRows decodes out (a JSON array of objects) into rows, collecting the
+// union of every row's keys as the column list in first-seen order.
+func walkRows(out *C.unqlite_value, rows *sqlRows) error {
+	state := &rowWalkState{rows: rows, cols: map[string]bool{}}
+	h := registerWalkState(state)
+	defer releaseWalkState(h)
+
+	rc := C.unqlite_array_walk(out, (*[0]byte)(C.goRowWalker), unsafe.Pointer(h))
+	if rc != C.UNQLITE_OK && rc != C.UNQLITE_DONE {
+		return fmt.Errorf("unqlite: error walking $%s: %w", outputVar, UnQLiteError(rc))
+	}
+	return nil
+}
+
+//export goRowWalker
+func goRowWalker(pKey, pValue *C.unqlite_value, pUserData unsafe.Pointer) C.int {
+	state := walkStateFor(pUserData)
+	if C.unqlite_value_is_json_object(pValue) != 1 {
+		return C.UNQLITE_INVALID
+	}
+
+	row := map[string]driver.Value{}
+	fieldState := &rowWalkState{rows: &sqlRows{rows: []map[string]driver.Value{row}}, cols: state.cols}
+	fh := registerWalkState(fieldState)
+	rc := C.unqlite_array_walk(pValue, (*[0]byte)(C.goFieldWalker), unsafe.Pointer(fh))
+	releaseWalkState(fh)
+	if rc != C.UNQLITE_OK && rc != C.UNQLITE_DONE {
+		return rc
+	}
+
+	for _, col := range fieldState.order {
+		if !state.cols[col] {
+			state.cols[col] = true
+			state.rows.cols = append(state.rows.cols, col)
+		}
+	}
+	state.rows.rows = append(state.rows.rows, row)
+	return C.UNQLITE_OK
+}
+
+//export goFieldWalker
+func goFieldWalker(pKey, pValue *C.unqlite_value, pUserData unsafe.Pointer) C.int {
+	state := walkStateFor(pUserData)
+	row := state.rows.rows[0]
+
+	keyZ := C.unqlite_value_to_string(pKey, nil)
+	key := C.GoString(keyZ)
+	state.order = append(state.order, key)
+
+	switch {
+	case C.unqlite_value_is_null(pValue) == 1:
+		row[key] = nil
+	case C.unqlite_value_is_int(pValue) == 1:
+		row[key] = int64(C.unqlite_value_to_int64(pValue))
+	case C.unqlite_value_is_float(pValue) == 1:
+		row[key] = float64(C.unqlite_value_to_double(pValue))
+	case C.unqlite_value_is_bool(pValue) == 1:
+		row[key] = C.unqlite_value_to_bool(pValue) == 1
+	default:
+		var n C.int
+		z := C.unqlite_value_to_string(pValue, &n)
+		row[key] = C.GoStringN(z, n)
+	}
+	return C.UNQLITE_OK
+}
@@ -0,0 +1,41 @@
+package unqlitego
+
+import "sync"
+
+// handleRegistry hands out opaque uintptr handles for values of type T, for
+// passing through a cgo pUserData void* without ever putting a Go pointer
+// on the C side of the call. This matters in particular for the walk-state
+// types that hold a slice/map themselves: passing such a struct directly
+// through unsafe.Pointer would be a Go pointer to Go pointers, which cgo's
+// pointer checker rejects. sinks, rowWalkState and valueWalkState all used
+// to carry their own copy of this map+mutex+counter; this is that scaffolding
+// factored into one place.
+type handleRegistry[T any] struct {
+	mu      sync.Mutex
+	entries map[uintptr]T
+	next    uintptr
+}
+
+func newHandleRegistry[T any]() *handleRegistry[T] {
+	return &handleRegistry[T]{entries: map[uintptr]T{}}
+}
+
+func (r *handleRegistry[T]) register(v T) uintptr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next++
+	r.entries[r.next] = v
+	return r.next
+}
+
+func (r *handleRegistry[T]) lookup(h uintptr) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries[h]
+}
+
+func (r *handleRegistry[T]) release(h uintptr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, h)
+}
@@ -15,6 +15,7 @@ import (
 	"log"
 	"runtime"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -70,12 +71,26 @@ type Database struct {
 
 	//Commit Unit Size: < 1 (After Each)
 	CommitAfter int
+
+	// Busy/Locked Retry
+	busyHandler func(attempt int) bool
+	busyTimeout time.Duration
+	busyMutex   sync.Mutex
+
+	// Backup Progress
+	backupProgress func(copied, total int64) bool
 }
 
 // Cursor ...
 type Cursor struct {
 	parent *Database
 	handle *C.unqlite_kv_cursor
+
+	// lastErr is set by rangeFrom when a KeyTo/ValueTo/Next call fails
+	// partway through an All/Range iteration. iter.Seq2 has no way to
+	// surface that error to the range loop itself, so it is stashed here
+	// for Err to report afterwards.
+	lastErr error
 }
 
 func init() {
@@ -85,41 +100,114 @@ func init() {
 	}
 }
 
-//Cache Open Databases
-var openDatabases = map[string]*Database{}
+// openHandle is the shared, reference-counted state behind every
+// *Database wrapper returned for the same filename by NewDatabase.
+type openHandle struct {
+	handle *C.unqlite
+	refs   int
+}
 
-// NewDatabase ...
+// openDatabases caches one openHandle per filename so repeated
+// NewDatabase(filename) calls share the same underlying unqlite*. It is
+// guarded by openDatabasesMutex: NewDatabase/Close both read and write it
+// from whatever goroutine collects a *Database's finalizer.
+var (
+	openDatabasesMutex sync.RWMutex
+	openDatabases      = map[string]*openHandle{}
+)
+
+// NewDatabase opens filename, or, if it is already open in this process,
+// returns a new wrapper sharing that handle. Each returned *Database is a
+// distinct value with its own Close: the underlying handle is only closed
+// once every wrapper for filename has been closed.
 func NewDatabase(filename string) (db *Database, err error) {
-	db = openDatabases[filename]
-
-	if db == nil {
-		db = &Database{}
-		db.name = filename
-		name := C.CString(filename)
-		defer C.free(unsafe.Pointer(name))
-		res := C.unqlite_open(&db.handle, name, C.UNQLITE_OPEN_CREATE)
-		if res != C.UNQLITE_OK {
-			err = UnQLiteError(res)
-		}
-		if db.handle != nil {
-			runtime.SetFinalizer(db, (*Database).Close)
-		}
-		openDatabases[filename] = db
+	openDatabasesMutex.Lock()
+	defer openDatabasesMutex.Unlock()
+
+	if entry := openDatabases[filename]; entry != nil {
+		entry.refs++
+		db = &Database{name: filename, handle: entry.handle}
+		runtime.SetFinalizer(db, (*Database).Close)
+		return db, nil
 	}
-	return
+
+	db = &Database{name: filename}
+	name := C.CString(filename)
+	defer C.free(unsafe.Pointer(name))
+	res := C.unqlite_open(&db.handle, name, C.UNQLITE_OPEN_CREATE)
+	if res != C.UNQLITE_OK {
+		return nil, UnQLiteError(res)
+	}
+
+	openDatabases[filename] = &openHandle{handle: db.handle, refs: 1}
+	runtime.SetFinalizer(db, (*Database).Close)
+	return db, nil
 }
 
-// Close ...
+// OpenExclusive opens filename with flags (an OR of the UNQLITE_OPEN_*
+// constants) bypassing the NewDatabase cache entirely, so the returned
+// *Database always owns an independent handle. Use this when you need
+// e.g. a read-only or in-memory handle alongside a cached read/write one.
+func OpenExclusive(filename string, flags int) (db *Database, err error) {
+	db = &Database{name: filename}
+	name := C.CString(filename)
+	defer C.free(unsafe.Pointer(name))
+	res := C.unqlite_open(&db.handle, name, C.int(flags))
+	if res != C.UNQLITE_OK {
+		return nil, UnQLiteError(res)
+	}
+	runtime.SetFinalizer(db, (*Database).Close)
+	return db, nil
+}
+
+// Open flags for OpenExclusive.
+const (
+	OpenCreate         = int(C.UNQLITE_OPEN_CREATE)
+	OpenReadOnly       = int(C.UNQLITE_OPEN_READONLY)
+	OpenMMap           = int(C.UNQLITE_OPEN_MMAP)
+	OpenTempDB         = int(C.UNQLITE_OPEN_TEMP_DB)
+	OpenInMemory       = int(C.UNQLITE_OPEN_IN_MEMORY)
+	OpenOmitJournaling = int(C.UNQLITE_OPEN_OMIT_JOURNALING)
+)
+
+// Close releases db's reference to its underlying handle. If db was
+// returned by NewDatabase and other wrappers for the same filename are
+// still open, the handle stays open until the last one closes. Close is
+// idempotent.
 func (db *Database) Close() (err error) {
-	if db.handle != nil {
+	openDatabasesMutex.Lock()
+	defer openDatabasesMutex.Unlock()
+
+	if db.handle == nil {
+		return nil
+	}
+
+	entry := openDatabases[db.name]
+	if entry == nil || entry.handle != db.handle {
+		// Opened via OpenExclusive/NewDatabaseWithOptions, or a stale
+		// cache entry for a different handle to the same filename: not
+		// shared, so close it unconditionally.
 		res := C.unqlite_close(db.handle)
+		db.handle = nil
 		if res != C.UNQLITE_OK {
 			err = UnQLiteError(res)
 		}
+		return err
+	}
+
+	entry.refs--
+	if entry.refs > 0 {
 		db.handle = nil
-		delete(openDatabases, db.name)
+		return nil
 	}
-	return
+
+	delete(openDatabases, db.name)
+	res := C.unqlite_close(entry.handle)
+	db.handle = nil
+	if res != C.UNQLITE_OK {
+		err = UnQLiteError(res)
+	}
+	return err
 }
 
 // Store ...
@@ -134,9 +222,11 @@ func (db *Database) Store(key, value []byte) (err error) {
 		v = unsafe.Pointer(&value[0])
 	}
 
-	res := C.unqlite_kv_store(db.handle,
-		k, C.int(len(key)),
-		v, C.unqlite_int64(len(value)))
+	res := db.retry(func() C.int {
+		return C.unqlite_kv_store(db.handle,
+			k, C.int(len(key)),
+			v, C.unqlite_int64(len(value)))
+	})
 	if res == C.UNQLITE_OK {
 		return nil
 	}
@@ -155,9 +245,11 @@ func (db *Database) Append(key, value []byte) (err error) {
 		v = unsafe.Pointer(&value[0])
 	}
 
-	res := C.unqlite_kv_append(db.handle,
-		k, C.int(len(key)),
-		v, C.unqlite_int64(len(value)))
+	res := db.retry(func() C.int {
+		return C.unqlite_kv_append(db.handle,
+			k, C.int(len(key)),
+			v, C.unqlite_int64(len(value)))
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -173,13 +265,17 @@ func (db *Database) Fetch(key []byte) (value []byte, err error) {
 	}
 
 	var n C.unqlite_int64
-	res := C.unqlite_kv_fetch(db.handle, k, C.int(len(key)), nil, &n)
+	res := db.retry(func() C.int {
+		return C.unqlite_kv_fetch(db.handle, k, C.int(len(key)), nil, &n)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 		return
 	}
 	value = make([]byte, int(n))
-	res = C.unqlite_kv_fetch(db.handle, k, C.int(len(key)), unsafe.Pointer(&value[0]), &n)
+	res = db.retry(func() C.int {
+		return C.unqlite_kv_fetch(db.handle, k, C.int(len(key)), unsafe.Pointer(&value[0]), &n)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -194,7 +290,9 @@ func (db *Database) Delete(key []byte) (err error) {
 		k = unsafe.Pointer(&key[0])
 	}
 
-	res := C.unqlite_kv_delete(db.handle, k, C.int(len(key)))
+	res := db.retry(func() C.int {
+		return C.unqlite_kv_delete(db.handle, k, C.int(len(key)))
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -212,10 +310,13 @@ func (db *Database) Begin() (err error) {
 
 // Commit ...
 func (db *Database) Commit() (err error) {
-	res := C.unqlite_commit(db.handle)
+	res := db.retry(func() C.int {
+		return C.unqlite_commit(db.handle)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
+	notifyUnlock()
 	return
 }
 
@@ -225,6 +326,7 @@ func (db *Database) Rollback() (err error) {
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
+	notifyUnlock()
 	return
 }
 
@@ -259,7 +361,9 @@ func (curs *Cursor) Seek(key []byte) (err error) {
 		k = unsafe.Pointer(&key[0])
 	}
 
-	res := C.unqlite_kv_cursor_seek(curs.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_EXACT)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_seek(curs.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_EXACT)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -274,7 +378,9 @@ func (curs *Cursor) SeekLE(key []byte) (err error) {
 		k = unsafe.Pointer(&key[0])
 	}
 
-	res := C.unqlite_kv_cursor_seek(curs.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_LE)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_seek(curs.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_LE)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -289,7 +395,9 @@ func (curs *Cursor) SeekGE(key []byte) (err error) {
 		k = unsafe.Pointer(&key[0])
 	}
 
-	res := C.unqlite_kv_cursor_seek(curs.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_GE)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_seek(curs.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_GE)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -298,7 +406,9 @@ func (curs *Cursor) SeekGE(key []byte) (err error) {
 
 // First ...
 func (curs *Cursor) First() (err error) {
-	res := C.unqlite_kv_cursor_first_entry(curs.handle)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_first_entry(curs.handle)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -307,7 +417,9 @@ func (curs *Cursor) First() (err error) {
 
 // Last ...
 func (curs *Cursor) Last() (err error) {
-	res := C.unqlite_kv_cursor_last_entry(curs.handle)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_last_entry(curs.handle)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -321,7 +433,9 @@ func (curs *Cursor) IsValid() (ok bool) {
 
 // Next ...
 func (curs *Cursor) Next() (err error) {
-	res := C.unqlite_kv_cursor_next_entry(curs.handle)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_next_entry(curs.handle)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -330,7 +444,9 @@ func (curs *Cursor) Next() (err error) {
 
 // Prev ...
 func (curs *Cursor) Prev() (err error) {
-	res := C.unqlite_kv_cursor_prev_entry(curs.handle)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_prev_entry(curs.handle)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -339,7 +455,9 @@ func (curs *Cursor) Prev() (err error) {
 
 // Delete ...
 func (curs *Cursor) Delete() (err error) {
-	res := C.unqlite_kv_cursor_delete_entry(curs.handle)
+	res := curs.parent.retry(func() C.int {
+		return C.unqlite_kv_cursor_delete_entry(curs.handle)
+	})
 	if res != C.UNQLITE_OK {
 		err = UnQLiteError(res)
 	}
@@ -525,22 +643,6 @@ func (t *Database) DeleteObject(key string) error {
 
 /* TODO: implement
 
-// Database Engine Handle
-int unqlite_config(unqlite *pDb,int nOp,...);
-
-// Key/Value (KV) Store Interfaces
-int unqlite_kv_fetch_callback(unqlite *pDb,const void *pKey,
-	                    int nKeyLen,int (*xConsumer)(const void *,unsigned int,void *),void *pUserData);
-int unqlite_kv_config(unqlite *pDb,int iOp,...);
-
-//  Cursor Iterator Interfaces
-int unqlite_kv_cursor_key_callback(unqlite_kv_cursor *pCursor,int (*xConsumer)(const void *,unsigned int,void *),void *pUserData);
-int unqlite_kv_cursor_data_callback(unqlite_kv_cursor *pCursor,int (*xConsumer)(const void *,unsigned int,void *),void *pUserData);
-
-// Utility interfaces
-int unqlite_util_load_mmaped_file(const char *zFile,void **ppMap,unqlite_int64 *pFileSize);
-int unqlite_util_release_mmaped_file(void *pMap,unqlite_int64 iFileSize);
-
 // Global Library Management Interfaces
 int unqlite_lib_config(int nConfigOp,...);
 */
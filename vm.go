@@ -0,0 +1,320 @@
+package unqlitego
+
+/*
+#include "./unqlite.h"
+#include <stdlib.h>
+
+extern int goVMOutputConsumer(void *pOutput, unsigned int nOutputLen, void *pUserData);
+extern int goVMValueWalker(unqlite_value *pKey, unqlite_value *pValue, void *pUserData);
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+// VM wraps a compiled Jx9 program (unqlite_vm), UnQLite's scripting engine
+// behind the document store (db_create, db_store, db_fetch_all, ...).
+type VM struct {
+	parent *Database
+	handle *C.unqlite_vm
+
+	hasOutputSink bool
+	outputSink    uintptr
+}
+
+// Compile compiles script as a Jx9 program against db. The returned *VM
+// must be released with VM.Release once it is no longer needed.
+func (db *Database) Compile(script string) (*VM, error) {
+	cz := C.CString(script)
+	defer C.free(unsafe.Pointer(cz))
+
+	vm := &VM{parent: db}
+	res := C.unqlite_compile(db.handle, cz, C.int(len(script)), &vm.handle)
+	if res != C.UNQLITE_OK {
+		return nil, UnQLiteError(res)
+	}
+	runtime.SetFinalizer(vm, (*VM).Release)
+	return vm, nil
+}
+
+// CompileFile compiles the Jx9 program stored at path against db.
+func (db *Database) CompileFile(path string) (*VM, error) {
+	cz := C.CString(path)
+	defer C.free(unsafe.Pointer(cz))
+
+	vm := &VM{parent: db}
+	res := C.unqlite_compile_file(db.handle, cz, &vm.handle)
+	if res != C.UNQLITE_OK {
+		return nil, UnQLiteError(res)
+	}
+	runtime.SetFinalizer(vm, (*VM).Release)
+	return vm, nil
+}
+
+// Exec runs the compiled program.
+func (vm *VM) Exec() error {
+	if res := C.unqlite_vm_exec(vm.handle); res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+	return nil
+}
+
+// Reset rewinds the VM so it can be re-executed, e.g. after rebinding
+// variables.
+func (vm *VM) Reset() error {
+	if res := C.unqlite_vm_reset(vm.handle); res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+	return nil
+}
+
+// Release frees the compiled program. It is safe to call more than once.
+func (vm *VM) Release() error {
+	if vm.hasOutputSink {
+		releaseSink(vm.outputSink)
+		vm.hasOutputSink = false
+	}
+	if vm.handle == nil {
+		return nil
+	}
+	res := C.unqlite_vm_release(vm.handle)
+	vm.handle = nil
+	if res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+	return nil
+}
+
+// ArgvEntry appends arg to the Jx9 $argv[] array, the same way argv is
+// populated for a Jx9 CLI invocation.
+func (vm *VM) ArgvEntry(arg string) error {
+	cz := C.CString(arg)
+	defer C.free(unsafe.Pointer(cz))
+
+	if res := C.unqlite_vm_config(vm.handle, C.UNQLITE_VM_CONFIG_ARGV_ENTRY, cz); res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+	return nil
+}
+
+// SetOutput redirects the program's print/echo stream to w instead of
+// stdout. Calling SetOutput again (e.g. after Reset, to reuse the VM)
+// releases the previous sink.
+func (vm *VM) SetOutput(w io.Writer) error {
+	h := registerSink(func(chunk []byte) error {
+		_, err := w.Write(chunk)
+		return err
+	})
+	res := C.unqlite_vm_config(vm.handle, C.UNQLITE_VM_CONFIG_OUTPUT,
+		(*[0]byte)(C.goVMOutputConsumer), unsafe.Pointer(h))
+	if res != C.UNQLITE_OK {
+		releaseSink(h)
+		return UnQLiteError(res)
+	}
+	if vm.hasOutputSink {
+		releaseSink(vm.outputSink)
+	}
+	vm.outputSink = h
+	vm.hasOutputSink = true
+	return nil
+}
+
+//export goVMOutputConsumer
+func goVMOutputConsumer(pOutput unsafe.Pointer, nOutputLen C.uint, pUserData unsafe.Pointer) C.int {
+	return goChunkConsumer(pOutput, nOutputLen, pUserData)
+}
+
+// Bind creates (or overwrites) the Jx9 variable name from the Go value v,
+// converting map[string]interface{} and []interface{} to Jx9
+// objects/arrays via unqlite_array_add_strkey_elem/unqlite_array_add_elem.
+// []byte is bound as a string; other map and slice types are rejected.
+func (vm *VM) Bind(name string, v interface{}) error {
+	val, err := vm.newValue(v)
+	if err != nil {
+		return err
+	}
+	defer C.unqlite_vm_release_value(vm.handle, val)
+
+	nz := C.CString(name)
+	defer C.free(unsafe.Pointer(nz))
+
+	if res := C.unqlite_vm_config(vm.handle, C.UNQLITE_VM_CONFIG_CREATE_VAR, nz, val); res != C.UNQLITE_OK {
+		return fmt.Errorf("unqlite: could not bind $%s: %w", name, UnQLiteError(res))
+	}
+	return nil
+}
+
+func (vm *VM) newValue(v interface{}) (*C.unqlite_value, error) {
+	switch t := v.(type) {
+	case nil:
+		val := C.unqlite_vm_new_scalar(vm.handle)
+		C.unqlite_value_null(val)
+		return val, nil
+	case bool:
+		val := C.unqlite_vm_new_scalar(vm.handle)
+		b := C.int(0)
+		if t {
+			b = 1
+		}
+		C.unqlite_value_bool(val, b)
+		return val, nil
+	case int:
+		return vm.newValue(int64(t))
+	case int64:
+		val := C.unqlite_vm_new_scalar(vm.handle)
+		C.unqlite_value_int64(val, C.unqlite_int64(t))
+		return val, nil
+	case float64:
+		val := C.unqlite_vm_new_scalar(vm.handle)
+		C.unqlite_value_double(val, C.double(t))
+		return val, nil
+	case string:
+		val := C.unqlite_vm_new_scalar(vm.handle)
+		cz := C.CString(t)
+		defer C.free(unsafe.Pointer(cz))
+		C.unqlite_value_string(val, cz, C.int(len(t)))
+		return val, nil
+	case []byte:
+		return vm.newValue(string(t))
+	case map[string]interface{}:
+		arr := C.unqlite_vm_new_array(vm.handle)
+		for k, elem := range t {
+			ev, err := vm.newValue(elem)
+			if err != nil {
+				C.unqlite_vm_release_value(vm.handle, arr)
+				return nil, err
+			}
+			kz := C.CString(k)
+			res := C.unqlite_array_add_strkey_elem(arr, kz, ev)
+			C.free(unsafe.Pointer(kz))
+			C.unqlite_vm_release_value(vm.handle, ev)
+			if res != C.UNQLITE_OK {
+				C.unqlite_vm_release_value(vm.handle, arr)
+				return nil, UnQLiteError(res)
+			}
+		}
+		return arr, nil
+	case []interface{}:
+		arr := C.unqlite_vm_new_array(vm.handle)
+		for _, elem := range t {
+			ev, err := vm.newValue(elem)
+			if err != nil {
+				C.unqlite_vm_release_value(vm.handle, arr)
+				return nil, err
+			}
+			res := C.unqlite_array_add_elem(arr, nil, ev)
+			C.unqlite_vm_release_value(vm.handle, ev)
+			if res != C.UNQLITE_OK {
+				C.unqlite_vm_release_value(vm.handle, arr)
+				return nil, UnQLiteError(res)
+			}
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("unqlite: cannot bind Go value of type %T", v)
+	}
+}
+
+// Extract reads the Jx9 variable name and unmarshals it into out using the
+// parent Database's Marshal/Unmarshal functions.
+func (vm *VM) Extract(name string, out interface{}) error {
+	nz := C.CString(name)
+	defer C.free(unsafe.Pointer(nz))
+
+	var val *C.unqlite_value
+	if res := C.unqlite_vm_config(vm.handle, C.UNQLITE_VM_CONFIG_EXTRACT_OUTPUT, nz, &val); res != C.UNQLITE_OK {
+		return fmt.Errorf("unqlite: could not extract $%s: %w", name, UnQLiteError(res))
+	}
+	if val == nil {
+		return nil
+	}
+
+	decoded, err := extractValue(val)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := vm.parent.Marshal()(decoded)
+	if err != nil {
+		return err
+	}
+	return vm.parent.Unmarshal()(encoded, out)
+}
+
+// extractValue walks a Jx9 unqlite_value into plain Go values
+// (map[string]interface{}, []interface{}, string, int64, float64, bool,
+// nil) suitable for re-marshalling.
+func extractValue(v *C.unqlite_value) (interface{}, error) {
+	switch {
+	case C.unqlite_value_is_null(v) == 1:
+		return nil, nil
+	case C.unqlite_value_is_json_object(v) == 1:
+		out := map[string]interface{}{}
+		state := &valueWalkState{obj: out}
+		h := registerValueWalkState(state)
+		C.unqlite_array_walk(v, (*[0]byte)(C.goVMValueWalker), unsafe.Pointer(h))
+		releaseValueWalkState(h)
+		return out, state.err
+	case C.unqlite_value_is_json_array(v) == 1:
+		var out []interface{}
+		state := &valueWalkState{list: &out}
+		h := registerValueWalkState(state)
+		C.unqlite_array_walk(v, (*[0]byte)(C.goVMValueWalker), unsafe.Pointer(h))
+		releaseValueWalkState(h)
+		return out, state.err
+	case C.unqlite_value_is_int(v) == 1:
+		return int64(C.unqlite_value_to_int64(v)), nil
+	case C.unqlite_value_is_float(v) == 1:
+		return float64(C.unqlite_value_to_double(v)), nil
+	case C.unqlite_value_is_bool(v) == 1:
+		return C.unqlite_value_to_bool(v) == 1, nil
+	default:
+		var n C.int
+		z := C.unqlite_value_to_string(v, &n)
+		return C.GoStringN(z, n), nil
+	}
+}
+
+// valueWalkState is threaded through goVMValueWalker via an opaque uintptr
+// handle (see valueWalkStates below), never a raw pointer: a struct holding
+// a map/slice is itself a Go pointer to Go pointers, which cgo's pointer
+// checker rejects when passed through unsafe.Pointer.
+type valueWalkState struct {
+	obj  map[string]interface{}
+	list *[]interface{}
+	err  error
+}
+
+var valueWalkStates = newHandleRegistry[*valueWalkState]()
+
+func registerValueWalkState(s *valueWalkState) uintptr {
+	return valueWalkStates.register(s)
+}
+
+func releaseValueWalkState(h uintptr) {
+	valueWalkStates.release(h)
+}
+
+//export goVMValueWalker
+func goVMValueWalker(pKey, pValue *C.unqlite_value, pUserData unsafe.Pointer) C.int {
+	state := valueWalkStates.lookup(uintptr(pUserData))
+
+	decoded, err := extractValue(pValue)
+	if err != nil {
+		state.err = err
+		return C.UNQLITE_ABORT
+	}
+
+	if state.obj != nil {
+		var n C.int
+		z := C.unqlite_value_to_string(pKey, &n)
+		state.obj[C.GoStringN(z, n)] = decoded
+	} else {
+		*state.list = append(*state.list, decoded)
+	}
+	return C.UNQLITE_OK
+}